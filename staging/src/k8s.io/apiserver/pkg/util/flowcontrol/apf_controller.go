@@ -0,0 +1,137 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package flowcontrol
+
+import (
+	"time"
+
+	apfmetrics "k8s.io/apiserver/pkg/util/flowcontrol/metrics"
+)
+
+// drainRetryAfter is the Retry-After duration given to requests shed by the
+// rebalancing drain loop. It's deliberately short: the point is to get a
+// well-behaved client to reconnect (ideally to a different apiserver) right
+// away, not to make it wait out a long backoff.
+const drainRetryAfter = 1 * time.Second
+
+// drainTickInterval is how often the background rebalancing drain loop
+// re-examines every priority level's overshoot and sheds another batch.
+// Shedding a large overshoot in capped batches across many ticks, rather
+// than all at once, is what keeps a single large config change from
+// producing a thundering herd of simultaneous rejections.
+const drainTickInterval = 250 * time.Millisecond
+
+// UpdateConcurrencyLimit is called whenever a priority level's computed
+// concurrency limit changes -- typically because its AssuredConcurrencyShares
+// was edited, or because the set of peer apiservers sharing the cluster's
+// total concurrency changed. It records the new limit; the background drain
+// loop started by New (see runDrainLoop) is what actually sheds any
+// resulting overshoot, in capped batches over successive ticks, until the
+// level's outstanding (in-use-plus-queued) depth is back within it.
+func (c *configController) UpdateConcurrencyLimit(priorityLevelName string, newConcurrencyLimit int) {
+	apfmetrics.SetPriorityLevelConcurrencyLimit(priorityLevelName, newConcurrencyLimit)
+
+	c.mu.Lock()
+	state, ok := c.levels[priorityLevelName]
+	c.mu.Unlock()
+	if !ok || state.queueSet == nil {
+		return
+	}
+
+	state.queueSet.SetConcurrencyLimit(newConcurrencyLimit)
+}
+
+// runDrainLoop periodically re-examines every known priority level's
+// overshoot and sheds another batch from any that are still over their
+// concurrency limit, so the overshoot left behind by a shrink is shed over
+// successive ticks instead of all at once, whether or not
+// UpdateConcurrencyLimit is called again.
+func (c *configController) runDrainLoop() {
+	ticker := time.NewTicker(drainTickInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.drainTick()
+	}
+}
+
+// drainTick is one pass of the rebalancing drain loop: every priority level
+// using the Queue limit response gets its overshoot recomputed against its
+// current concurrency limit, and -- if still positive -- another capped
+// batch shed from its queues.
+func (c *configController) drainTick() {
+	type namedLevel struct {
+		name  string
+		state *priorityLevelState
+	}
+
+	c.mu.Lock()
+	levels := make([]namedLevel, 0, len(c.levels))
+	for name, state := range c.levels {
+		if state.queueSet != nil {
+			levels = append(levels, namedLevel{name, state})
+		}
+	}
+	c.mu.Unlock()
+
+	totalOutstanding := c.totalOutstanding()
+	for _, level := range levels {
+		priorityLevelName, state := level.name, level.state
+		overshoot := state.queueSet.Overshoot()
+		if overshoot <= 0 {
+			apfmetrics.SetPriorityLevelOvershoot(priorityLevelName, 0)
+			continue
+		}
+		apfmetrics.SetPriorityLevelOvershoot(priorityLevelName, overshoot)
+
+		batch := drainBatchSize(overshoot, totalOutstanding)
+		shed := state.queueSet.ShedOldest(batch, drainRetryAfter)
+		apfmetrics.AddDrainedRequests(priorityLevelName, "concurrency-shrink", shed)
+	}
+}
+
+// totalOutstanding sums the in-use-plus-queued depth across every priority
+// level this controller knows about; it's the "N" that scales how
+// aggressively a single level's overshoot is drained per tick.
+func (c *configController) totalOutstanding() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var total int
+	for _, state := range c.levels {
+		if state.queueSet != nil {
+			total += state.queueSet.Outstanding()
+		}
+	}
+	return total
+}
+
+// drainBatchSize caps how many requests are shed in a single rebalancing
+// pass to max(1, overshoot/totalOutstanding), so that the drain rate scales
+// down as total outstanding work across all priority levels grows.
+func drainBatchSize(overshoot, totalOutstanding int) int {
+	n := totalOutstanding
+	if n < 1 {
+		n = 1
+	}
+	batch := overshoot / n
+	if batch < 1 {
+		batch = 1
+	}
+	if batch > overshoot {
+		batch = overshoot
+	}
+	return batch
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package flowcontrol implements the filter that applies API Priority and
+// Fairness to incoming requests: each request, already classified to a
+// FlowSchema/PriorityLevelConfiguration pair, is queued, executed, or
+// rejected according to that priority level's configuration.
+package flowcontrol
+
+import (
+	"sync"
+	"time"
+
+	flowcontrol "k8s.io/api/flowcontrol/v1beta1"
+	apfmetrics "k8s.io/apiserver/pkg/util/flowcontrol/metrics"
+	"k8s.io/apiserver/pkg/util/flowcontrol/request"
+)
+
+// Outcome describes what happened to a request handled by the filter.
+type Outcome struct {
+	// Executed is true if the handler ran the request to completion.
+	Executed bool
+	// Rejected is true if the request was turned away -- either immediately,
+	// because the queue was already backed up past MaxQueueTimeSeconds or its
+	// chosen queue was at QueueLengthLimit, or after waiting, once it crossed
+	// MaxQueueTimeSeconds itself or was shed by the rebalancing drain loop.
+	Rejected bool
+	// RetryAfter is set whenever Rejected is true. For a request rejected
+	// immediately it is MaxQueueTimeSeconds, which is zero if that is unset.
+	RetryAfter time.Duration
+}
+
+// priorityLevelState is the per-priority-level bookkeeping the filter needs:
+// its QueueSet, for levels using the Queue limit response, and its
+// longRunningBucket, for levels carving out a dedicated long-running
+// concurrency share.
+type priorityLevelState struct {
+	queueSet    *request.QueueSet
+	longRunning *longRunningBucket
+}
+
+// Interface is the filter's entry point, invoked once per incoming request
+// after it has been classified to a FlowSchema/PriorityLevelConfiguration.
+type Interface interface {
+	// Handle runs execute() if and when the request is admitted, and
+	// reports what happened. Exempt priority levels and levels with the
+	// Reject limit response run execute() immediately; for the Queue limit
+	// response, execute is not called if the request is rejected.
+	Handle(priorityLevelName, flowSchemaName string, spec flowcontrol.PriorityLevelConfigurationSpec, flowDistinguisher string, execute func()) Outcome
+
+	// UpdateConcurrencyLimit is called whenever a priority level's computed
+	// concurrency limit changes, and records the new limit; the background
+	// drain loop then sheds any resulting overshoot from its queues. See
+	// apf_controller.go for details.
+	UpdateConcurrencyLimit(priorityLevelName string, newConcurrencyLimit int)
+
+	// HandleLongRunning admits or rejects a long-running request (e.g. a
+	// watch) against the priority level's dedicated long-running
+	// concurrency share, if it has one. See longrunning.go for details.
+	HandleLongRunning(priorityLevelName, flowSchemaName string, spec flowcontrol.PriorityLevelConfigurationSpec) (admit bool, finished func())
+}
+
+type configController struct {
+	mu     sync.Mutex
+	levels map[string]*priorityLevelState
+}
+
+// New constructs the priority-and-fairness filter implementation and starts
+// its background rebalancing drain loop (see apf_controller.go).
+func New() Interface {
+	apfmetrics.Register()
+	c := &configController{levels: map[string]*priorityLevelState{}}
+	go c.runDrainLoop()
+	return c
+}
+
+func (c *configController) stateFor(priorityLevelName string, limited *flowcontrol.LimitedPriorityLevelConfiguration) *priorityLevelState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, ok := c.levels[priorityLevelName]
+	if ok {
+		return state
+	}
+	state = &priorityLevelState{}
+	if limited.LimitResponse.Type == flowcontrol.LimitResponseTypeQueue && limited.LimitResponse.Queuing != nil {
+		state.queueSet = request.NewQueueSet(priorityLevelName, *limited.LimitResponse.Queuing, int(limited.AssuredConcurrencyShares))
+	}
+	if limited.LongRunningConcurrencyShares > 0 {
+		state.longRunning = newLongRunningBucket(int(limited.LongRunningConcurrencyShares))
+	}
+	c.levels[priorityLevelName] = state
+	return state
+}
+
+func (c *configController) Handle(priorityLevelName, flowSchemaName string, spec flowcontrol.PriorityLevelConfigurationSpec, flowDistinguisher string, execute func()) Outcome {
+	if spec.Type == flowcontrol.PriorityLevelEnablementExempt || spec.Limited == nil {
+		execute()
+		return Outcome{Executed: true}
+	}
+
+	if spec.Limited.LimitResponse.Type != flowcontrol.LimitResponseTypeQueue || spec.Limited.LimitResponse.Queuing == nil {
+		// Reject limit response: admission/concurrency limiting for this
+		// case is handled by the existing (unmodified) concurrency
+		// semaphore elsewhere in the filter chain; this function only
+		// covers the Queue limit response.
+		execute()
+		return Outcome{Executed: true}
+	}
+
+	state := c.stateFor(priorityLevelName, spec.Limited)
+
+	arrival := time.Now()
+	req := state.queueSet.Enqueue(flowDistinguisher)
+	if req == nil {
+		retryAfter := time.Duration(spec.Limited.LimitResponse.Queuing.MaxQueueTimeSeconds) * time.Second
+		apfmetrics.ObserveWaitingDuration(priorityLevelName, flowSchemaName, false, 0)
+		return Outcome{Rejected: true, RetryAfter: retryAfter}
+	}
+
+	// Block until the request is either admitted to execute (a concurrency
+	// slot freed up), rejected for having waited past MaxQueueTimeSeconds
+	// (by the QueueSet's timeout reaper), or shed by the controller's
+	// rebalancing drain because the priority level's concurrency shrank out
+	// from under it (see UpdateConcurrencyLimit in apf_controller.go).
+	decision := <-req.Done
+	apfmetrics.ObserveWaitingDuration(priorityLevelName, flowSchemaName, decision.Execute, time.Since(arrival))
+	if !decision.Execute {
+		return Outcome{Rejected: true, RetryAfter: decision.RetryAfter}
+	}
+
+	execute()
+	state.queueSet.Finish(req)
+	return Outcome{Executed: true}
+}
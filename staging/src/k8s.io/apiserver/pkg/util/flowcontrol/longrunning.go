@@ -0,0 +1,96 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package flowcontrol
+
+import (
+	"sync"
+
+	flowcontrol "k8s.io/api/flowcontrol/v1beta1"
+	apfmetrics "k8s.io/apiserver/pkg/util/flowcontrol/metrics"
+)
+
+// longRunningBucket tracks how many long-running requests (watches, exec,
+// port-forward, ...) are currently open against a priority level's dedicated
+// LongRunningConcurrencyShares, so that such requests are accounted for
+// separately from -- and can't starve -- ordinary requests sharing the same
+// priority level, instead of being exempted from APF accounting entirely.
+type longRunningBucket struct {
+	mu    sync.Mutex
+	limit int
+	open  map[string]int // open count by flow schema name, for gauge labeling
+}
+
+func newLongRunningBucket(limit int) *longRunningBucket {
+	return &longRunningBucket{limit: limit, open: map[string]int{}}
+}
+
+func (b *longRunningBucket) totalLocked() int {
+	var total int
+	for _, n := range b.open {
+		total += n
+	}
+	return total
+}
+
+// start admits one more long-running request against the bucket if it has
+// room, recording it against flowSchemaName. It returns the new count for
+// flowSchemaName and whether the request was admitted.
+func (b *longRunningBucket) start(flowSchemaName string) (count int, admitted bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.limit > 0 && b.totalLocked() >= b.limit {
+		return b.open[flowSchemaName], false
+	}
+	b.open[flowSchemaName]++
+	return b.open[flowSchemaName], true
+}
+
+// finish releases one long-running request previously admitted under
+// flowSchemaName, returning the new count for it.
+func (b *longRunningBucket) finish(flowSchemaName string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.open[flowSchemaName] > 0 {
+		b.open[flowSchemaName]--
+	}
+	return b.open[flowSchemaName]
+}
+
+// HandleLongRunning admits or rejects a long-running request against
+// priorityLevelName's dedicated long-running concurrency share, if it has
+// one. If the priority level is exempt, has no Limited configuration, or has
+// a zero LongRunningConcurrencyShares, the request is always admitted and
+// counted exactly as before: not at all. Otherwise it is admitted only if
+// the bucket has room, and the apiserver_flowcontrol_current_watches gauge
+// is kept up to date for flowSchemaName across both start and finish.
+func (c *configController) HandleLongRunning(priorityLevelName, flowSchemaName string, spec flowcontrol.PriorityLevelConfigurationSpec) (admit bool, finished func()) {
+	noop := func() {}
+	if spec.Type == flowcontrol.PriorityLevelEnablementExempt || spec.Limited == nil || spec.Limited.LongRunningConcurrencyShares <= 0 {
+		return true, noop
+	}
+
+	state := c.stateFor(priorityLevelName, spec.Limited)
+	count, admitted := state.longRunning.start(flowSchemaName)
+	if !admitted {
+		return false, noop
+	}
+	apfmetrics.SetCurrentWatches(priorityLevelName, flowSchemaName, count)
+
+	return true, func() {
+		apfmetrics.SetCurrentWatches(priorityLevelName, flowSchemaName, state.longRunning.finish(flowSchemaName))
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics provides the prometheus metrics emitted by the API
+// Priority and Fairness filter, and the helpers used to update them.
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+const namespace = "apiserver"
+const subsystem = "flowcontrol"
+
+var (
+	priorityLevelConcurrencyLimit = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Namespace:      namespace,
+			Subsystem:      subsystem,
+			Name:           "request_concurrency_limit",
+			Help:           "Shared concurrency limit in the API Priority and Fairness filter, by priority level",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"priority_level"},
+	)
+
+	requestWaitDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Namespace:      namespace,
+			Subsystem:      subsystem,
+			Name:           "request_wait_duration_seconds",
+			Help:           "Length of time a request spent waiting in its queue",
+			Buckets:        []float64{0, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2, 4, 8, 15, 30, 60},
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"priority_level", "flow_schema", "execute"},
+	)
+
+	drainedRequestsTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      namespace,
+			Subsystem:      subsystem,
+			Name:           "drained_requests_total",
+			Help:           "Number of requests drained (rejected) from a priority level's queues by the controller's rebalancing loop, by priority level and reason",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"priority_level", "reason"},
+	)
+
+	priorityLevelOvershoot = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Namespace:      namespace,
+			Subsystem:      subsystem,
+			Name:           "priority_level_request_overshoot",
+			Help:           "How far a priority level's in-use-plus-queued request depth currently exceeds its computed concurrency limit",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"priority_level"},
+	)
+
+	currentWatches = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Namespace:      namespace,
+			Subsystem:      subsystem,
+			Name:           "current_watches",
+			Help:           "Number of currently open long-running requests (e.g. watches) accounted against a priority level's dedicated long-running concurrency share, by priority level and flow schema",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"priority_level", "flow_schema"},
+	)
+
+	registerMetricsOnce sync.Once
+)
+
+// Register registers the flow control metrics with the legacy
+// (global) Prometheus registry. It is idempotent.
+func Register() {
+	registerMetricsOnce.Do(func() {
+		for _, m := range []metrics.Registerable{
+			priorityLevelConcurrencyLimit,
+			requestWaitDuration,
+			drainedRequestsTotal,
+			priorityLevelOvershoot,
+			currentWatches,
+		} {
+			legacyregistry.MustRegister(m)
+		}
+	})
+}
+
+// SetPriorityLevelConcurrencyLimit records the computed concurrency limit
+// for a priority level.
+func SetPriorityLevelConcurrencyLimit(priorityLevel string, limit int) {
+	priorityLevelConcurrencyLimit.WithLabelValues(priorityLevel).Set(float64(limit))
+}
+
+// ObserveWaitingDuration records how long a request with the given outcome
+// (executed or not) waited in the given priority level's queues before the
+// outcome was decided.
+func ObserveWaitingDuration(priorityLevel, flowSchema string, executed bool, waitTime time.Duration) {
+	execLabel := "false"
+	if executed {
+		execLabel = "true"
+	}
+	requestWaitDuration.WithLabelValues(priorityLevel, flowSchema, execLabel).Observe(waitTime.Seconds())
+}
+
+// AddDrainedRequests records that count requests were drained (rejected) from
+// priorityLevel's queues for the given reason (e.g. "concurrency-shrink").
+func AddDrainedRequests(priorityLevel, reason string, count int) {
+	if count <= 0 {
+		return
+	}
+	drainedRequestsTotal.WithLabelValues(priorityLevel, reason).Add(float64(count))
+}
+
+// SetPriorityLevelOvershoot records how far a priority level's current
+// in-use-plus-queued depth exceeds its computed concurrency limit.
+func SetPriorityLevelOvershoot(priorityLevel string, overshoot int) {
+	priorityLevelOvershoot.WithLabelValues(priorityLevel).Set(float64(overshoot))
+}
+
+// SetCurrentWatches records how many long-running requests are currently
+// open against a priority level's dedicated long-running concurrency share,
+// for the given flow schema.
+func SetCurrentWatches(priorityLevel, flowSchema string, count int) {
+	currentWatches.WithLabelValues(priorityLevel, flowSchema).Set(float64(count))
+}
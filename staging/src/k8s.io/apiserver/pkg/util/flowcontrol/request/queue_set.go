@@ -0,0 +1,407 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package request holds the queuing logic used by the API Priority and
+// Fairness filter to hold requests that cannot be executed immediately.
+package request
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	flowcontrol "k8s.io/api/flowcontrol/v1beta1"
+)
+
+// Decision is the outcome of a request that was put in a queue: either it
+// was admitted to execute, or it was rejected.
+type Decision struct {
+	// Execute is true if the request should proceed.
+	Execute bool
+	// RetryAfter is set on rejection, for use in the response's Retry-After
+	// header.
+	RetryAfter time.Duration
+}
+
+// Request is a single request that has been placed into one of a
+// QueueSet's queues, awaiting either admission to execute or rejection.
+// Done receives exactly one Decision, once the request leaves the queue.
+type Request struct {
+	ArrivalTime time.Time
+	Done        chan Decision
+}
+
+// queue is one shuffle-sharded queue of a QueueSet.
+type queue struct {
+	requests []*Request
+}
+
+// oldestArrival reports the arrival time of the queue's oldest still-queued
+// request, and whether the queue is non-empty.
+func (q *queue) oldestArrival() (time.Time, bool) {
+	if len(q.requests) == 0 {
+		return time.Time{}, false
+	}
+	return q.requests[0].ArrivalTime, true
+}
+
+// QueueSet holds the queues of a single priority level and enforces its
+// QueuingConfiguration: requests in excess of the priority level's
+// concurrency limit wait in a queue, chosen by shuffle-sharding the
+// request's flow distinguisher across a hand of HandSize queues, until a
+// concurrency slot frees up; a queue already at QueueLengthLimit rejects new
+// arrivals outright; and once any queue holds a request older than
+// MaxQueueTimeSeconds, new arrivals are rejected outright while a background
+// reaper dequeues and rejects individual requests as soon as they cross the
+// bound themselves -- rather than either backlog growing without limit.
+type QueueSet struct {
+	name   string
+	config flowcontrol.QueuingConfiguration
+
+	mu               sync.Mutex
+	queues           []*queue
+	concurrencyLimit int
+	executing        int
+	closed           chan struct{}
+}
+
+// NewQueueSet creates a QueueSet enforcing the given configuration and
+// concurrency limit (typically a priority level's AssuredConcurrencyShares).
+// name is used only for logging/metrics labeling by callers.
+func NewQueueSet(name string, config flowcontrol.QueuingConfiguration, concurrencyLimit int) *QueueSet {
+	numQueues := config.Queues
+	if numQueues < 1 {
+		numQueues = 1
+	}
+	if concurrencyLimit < 1 {
+		concurrencyLimit = 1
+	}
+	qs := &QueueSet{
+		name:             name,
+		config:           config,
+		queues:           make([]*queue, numQueues),
+		concurrencyLimit: concurrencyLimit,
+		closed:           make(chan struct{}),
+	}
+	for i := range qs.queues {
+		qs.queues[i] = &queue{}
+	}
+	if qs.maxQueueTime() > 0 {
+		go qs.runTimeoutReaper()
+	}
+	return qs
+}
+
+// Close stops the QueueSet's background timeout reaper. Safe to call more
+// than once.
+func (qs *QueueSet) Close() {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	select {
+	case <-qs.closed:
+	default:
+		close(qs.closed)
+	}
+}
+
+func (qs *QueueSet) maxQueueTime() time.Duration {
+	return time.Duration(qs.config.MaxQueueTimeSeconds) * time.Second
+}
+
+// oldestQueuedAgeLocked returns how long the oldest request across all
+// queues has been waiting, or zero if every queue is empty. Callers must
+// hold qs.mu.
+func (qs *QueueSet) oldestQueuedAgeLocked(now time.Time) time.Duration {
+	var oldest time.Duration
+	for _, q := range qs.queues {
+		arrival, ok := q.oldestArrival()
+		if !ok {
+			continue
+		}
+		if age := now.Sub(arrival); age > oldest {
+			oldest = age
+		}
+	}
+	return oldest
+}
+
+// handSizeLocked returns the effective HandSize: the configured value,
+// clamped to the number of queues, or every queue if HandSize is unset.
+// Callers must hold qs.mu.
+func (qs *QueueSet) handSizeLocked() int {
+	hand := int(qs.config.HandSize)
+	if hand <= 0 || hand > len(qs.queues) {
+		return len(qs.queues)
+	}
+	return hand
+}
+
+// queueIndexForLocked shuffle-shards flowDistinguisher into a hand of
+// HandSize queues -- deterministically, so the same flow always draws the
+// same hand -- and returns the shortest queue in that hand, preferring the
+// lowest index on ties. Callers must hold qs.mu.
+func (qs *QueueSet) queueIndexForLocked(flowDistinguisher string) int {
+	hand := qs.handSizeLocked()
+	if hand >= len(qs.queues) {
+		return qs.shortestOfLocked(allIndices(len(qs.queues)))
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(flowDistinguisher))
+	perm := rand.New(rand.NewSource(int64(h.Sum64()))).Perm(len(qs.queues))
+	return qs.shortestOfLocked(perm[:hand])
+}
+
+// shortestOfLocked returns the index, among candidates, of the queue with
+// the fewest currently-queued requests, preferring the lowest index on
+// ties. Callers must hold qs.mu.
+func (qs *QueueSet) shortestOfLocked(candidates []int) int {
+	best := candidates[0]
+	for _, i := range candidates[1:] {
+		n, bestN := len(qs.queues[i].requests), len(qs.queues[best].requests)
+		if n < bestN || (n == bestN && i < best) {
+			best = i
+		}
+	}
+	return best
+}
+
+func allIndices(n int) []int {
+	indices := make([]int, n)
+	for i := range indices {
+		indices[i] = i
+	}
+	return indices
+}
+
+// Enqueue places a new request into the queue chosen by shuffle-sharding
+// flowDistinguisher, then admits it (and any other eligible queued
+// requests) if a concurrency slot is free. It returns nil if the request is
+// rejected outright: either the priority level already has a backlog older
+// than MaxQueueTimeSeconds -- admitting more work behind an already-late
+// queue would only make every later request pay the same cost -- or the
+// chosen queue is already at QueueLengthLimit.
+func (qs *QueueSet) Enqueue(flowDistinguisher string) *Request {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	now := time.Now()
+	if maxWait := qs.maxQueueTime(); maxWait > 0 && qs.oldestQueuedAgeLocked(now) > maxWait {
+		return nil
+	}
+
+	q := qs.queues[qs.queueIndexForLocked(flowDistinguisher)]
+	if limit := qs.config.QueueLengthLimit; limit > 0 && len(q.requests) >= int(limit) {
+		return nil
+	}
+
+	req := &Request{ArrivalTime: now, Done: make(chan Decision, 1)}
+	q.requests = append(q.requests, req)
+	qs.admitLocked()
+	return req
+}
+
+// admitLocked admits the oldest still-queued requests across all queues,
+// one at a time, for as long as the concurrency limit allows, removing each
+// from its queue and waking its caller via Done. Callers must hold qs.mu.
+func (qs *QueueSet) admitLocked() {
+	for qs.executing < qs.concurrencyLimit {
+		req, ok := qs.popOldestLocked()
+		if !ok {
+			return
+		}
+		qs.executing++
+		select {
+		case req.Done <- Decision{Execute: true}:
+		default:
+		}
+	}
+}
+
+// popOldestLocked removes and returns the oldest still-queued request
+// across all queues. Callers must hold qs.mu.
+func (qs *QueueSet) popOldestLocked() (*Request, bool) {
+	var oldestQueue *queue
+	var oldest *Request
+	for _, q := range qs.queues {
+		arrival, ok := q.oldestArrival()
+		if !ok {
+			continue
+		}
+		if oldest == nil || arrival.Before(oldest.ArrivalTime) {
+			oldest = q.requests[0]
+			oldestQueue = q
+		}
+	}
+	if oldest == nil {
+		return nil, false
+	}
+	oldestQueue.requests = oldestQueue.requests[1:]
+	return oldest, true
+}
+
+// Finish releases the concurrency slot held by req -- which must have
+// previously been admitted via a Decision with Execute set to true -- and
+// admits the next eligible queued request(s), if any are waiting.
+func (qs *QueueSet) Finish(req *Request) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	if qs.executing > 0 {
+		qs.executing--
+	}
+	qs.admitLocked()
+}
+
+// SetConcurrencyLimit updates the QueueSet's concurrency limit, e.g. when
+// the priority level's AssuredConcurrencyShares is recomputed, admitting any
+// now-eligible queued requests if the limit grew. It returns the limit
+// actually applied (clamped to at least 1), so callers computing overshoot
+// against it stay consistent with what the QueueSet enforces.
+func (qs *QueueSet) SetConcurrencyLimit(limit int) int {
+	if limit < 1 {
+		limit = 1
+	}
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	qs.concurrencyLimit = limit
+	qs.admitLocked()
+	return limit
+}
+
+// Outstanding returns the total number of requests the QueueSet is
+// currently responsible for -- both still-queued and already admitted and
+// executing -- as a single atomic snapshot, so a request transitioning
+// between the two across separate reads can't be double-counted (or
+// missed).
+func (qs *QueueSet) Outstanding() int {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	return qs.outstandingLocked()
+}
+
+func (qs *QueueSet) outstandingLocked() int {
+	outstanding := qs.executing
+	for _, q := range qs.queues {
+		outstanding += len(q.requests)
+	}
+	return outstanding
+}
+
+// Overshoot returns how far the QueueSet's outstanding (in-use-plus-queued)
+// depth currently exceeds its concurrency limit, as a single atomic
+// snapshot of both -- or a non-positive number if it doesn't.
+func (qs *QueueSet) Overshoot() int {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	return qs.outstandingLocked() - qs.concurrencyLimit
+}
+
+// ShedOldest rejects the n oldest still-queued requests across all queues
+// (oldest first), regardless of MaxQueueTimeSeconds, and returns how many
+// were actually shed. It is used to drain a priority level's backlog when
+// its concurrency limit shrinks below its current depth -- rejecting them
+// with retryAfter lets well-behaved clients reconnect to a less-loaded peer
+// instead of waiting against a limit that no longer has room for them.
+// Requests already admitted and executing are never shed.
+func (qs *QueueSet) ShedOldest(n int, retryAfter time.Duration) int {
+	if n <= 0 {
+		return 0
+	}
+
+	qs.mu.Lock()
+	var all []*Request
+	for _, q := range qs.queues {
+		all = append(all, q.requests...)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].ArrivalTime.Before(all[j].ArrivalTime)
+	})
+	if n > len(all) {
+		n = len(all)
+	}
+	toShed := all[:n]
+
+	shedSet := make(map[*Request]bool, n)
+	for _, req := range toShed {
+		shedSet[req] = true
+	}
+	for qi, q := range qs.queues {
+		kept := q.requests[:0]
+		for _, req := range q.requests {
+			if !shedSet[req] {
+				kept = append(kept, req)
+			}
+		}
+		qs.queues[qi].requests = kept
+	}
+	qs.mu.Unlock()
+
+	for _, req := range toShed {
+		select {
+		case req.Done <- Decision{Execute: false, RetryAfter: retryAfter}:
+		default:
+		}
+	}
+	return len(toShed)
+}
+
+// runTimeoutReaper periodically dequeues and rejects requests that have
+// individually waited longer than MaxQueueTimeSeconds, so a client isn't
+// left hanging on a queued request indefinitely once it has already missed
+// its latency bound.
+func (qs *QueueSet) runTimeoutReaper() {
+	maxWait := qs.maxQueueTime()
+	tick := maxWait / 4
+	if tick <= 0 {
+		tick = time.Second
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-qs.closed:
+			return
+		case now := <-ticker.C:
+			qs.rejectExpired(now, maxWait)
+		}
+	}
+}
+
+func (qs *QueueSet) rejectExpired(now time.Time, maxWait time.Duration) {
+	qs.mu.Lock()
+	var expired []*Request
+	for _, q := range qs.queues {
+		kept := q.requests[:0]
+		for _, req := range q.requests {
+			if now.Sub(req.ArrivalTime) > maxWait {
+				expired = append(expired, req)
+				continue
+			}
+			kept = append(kept, req)
+		}
+		q.requests = kept
+	}
+	qs.mu.Unlock()
+
+	for _, req := range expired {
+		select {
+		case req.Done <- Decision{Execute: false, RetryAfter: maxWait}:
+		default:
+		}
+	}
+}
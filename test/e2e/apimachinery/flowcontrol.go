@@ -21,7 +21,9 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -32,7 +34,9 @@ import (
 
 	flowcontrol "k8s.io/api/flowcontrol/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/kubernetes/test/e2e/framework"
 )
@@ -40,6 +44,20 @@ import (
 const (
 	requestConcurrencyLimitMetricName      = "apiserver_flowcontrol_request_concurrency_limit"
 	requestConcurrencyLimitMetricLabelName = "priority_level"
+
+	// requestWaitDurationMetricName is the histogram of how long a request
+	// spent waiting in an APF queue before being admitted or rejected.
+	requestWaitDurationMetricName = "apiserver_flowcontrol_request_wait_duration_seconds"
+
+	// drainedRequestsTotalMetricName is the counter of requests shed by the
+	// APF controller's drain loop when a priority level's concurrency shares
+	// shrink below its current in-use+queued depth.
+	drainedRequestsTotalMetricName = "apiserver_flowcontrol_drained_requests_total"
+
+	// currentWatchesMetricName is the gauge of watches currently open and
+	// accounted against a priority level's long-running concurrency bucket.
+	currentWatchesMetricName  = "apiserver_flowcontrol_current_watches"
+	flowSchemaMetricLabelName = "flow_schema"
 )
 
 var _ = SIGDescribe("API priority and fairness", func() {
@@ -52,7 +70,7 @@ var _ = SIGDescribe("API priority and fairness", func() {
 		nonMatchingUsername := "foo"
 
 		ginkgo.By("creating a testing prioritylevel")
-		createdPriorityLevel, cleanup := createPriorityLevel(f, testingPriorityLevelName, 1)
+		createdPriorityLevel, cleanup := createPriorityLevel(f, testingPriorityLevelName, 1, nil, nil)
 		defer cleanup()
 
 		ginkgo.By("creating a testing flowschema")
@@ -101,7 +119,7 @@ var _ = SIGDescribe("API priority and fairness", func() {
 		for i := range clients {
 			clients[i].priorityLevelName = fmt.Sprintf("%s-%s", priorityLevelNamePrefix, clients[i].username)
 			framework.Logf("creating PriorityLevel %q", clients[i].priorityLevelName)
-			_, cleanup := createPriorityLevel(f, clients[i].priorityLevelName, 1)
+			_, cleanup := createPriorityLevel(f, clients[i].priorityLevelName, 1, nil, nil)
 			defer cleanup()
 
 			clients[i].flowSchemaName = fmt.Sprintf("%s-%s", flowSchemaNamePrefix, clients[i].username)
@@ -155,7 +173,7 @@ var _ = SIGDescribe("API priority and fairness", func() {
 		loadDuration := 10 * time.Second
 
 		framework.Logf("creating PriorityLevel %q", priorityLevelName)
-		_, cleanup := createPriorityLevel(f, priorityLevelName, 1)
+		_, cleanup := createPriorityLevel(f, priorityLevelName, 1, nil, nil)
 		defer cleanup()
 
 		framework.Logf("creating FlowSchema %q", flowSchemaName)
@@ -207,11 +225,221 @@ var _ = SIGDescribe("API priority and fairness", func() {
 			}
 		}
 	})
+
+	// This test creates a single priority level with a Queue limit response and
+	// a small MaxQueueTimeSeconds, then overloads it well past its assured
+	// concurrency. We expect excess requests to be queued (rather than
+	// rejected outright), but any request that has been sitting in the queue
+	// longer than MaxQueueTimeSeconds should be rejected with a 503 and a
+	// Retry-After header instead of being admitted arbitrarily late.
+	ginkgo.It("should ensure that requests are queued and time out when max queue wait is exceeded (Queue limit response)", func() {
+		priorityLevelName := "e2e-testing-prioritylevel-queue"
+		flowSchemaName := "e2e-testing-flowschema-queue"
+		loadDuration := 10 * time.Second
+		maxQueueTimeSeconds := int32(2)
+
+		ginkgo.By("creating a testing prioritylevel with a Queue limit response")
+		_, cleanup := createPriorityLevel(f, priorityLevelName, 1, &flowcontrol.QueuingConfiguration{
+			Queues:              64,
+			HandSize:            4,
+			QueueLengthLimit:    50,
+			MaxQueueTimeSeconds: maxQueueTimeSeconds,
+		}, nil)
+		defer cleanup()
+
+		ginkgo.By("creating a testing flowschema")
+		_, cleanup = createFlowSchema(f, flowSchemaName, 1000, priorityLevelName, "noxu")
+		defer cleanup()
+
+		ginkgo.By(fmt.Sprintf("starting overloading QPS load for %s", loadDuration.String()))
+		results := uniformQPSLoadConcurrentWithStatus(f, "noxu", 20, 50.0, loadDuration)
+
+		framework.Logf("load produced %d successes and %d 503s out of %d total requests",
+			results.succeeded, results.serviceUnavailable, results.succeeded+results.serviceUnavailable)
+		if results.succeeded == 0 {
+			framework.Failf("expected at least some requests to be queued and admitted, got none")
+		}
+		if results.serviceUnavailable == 0 {
+			framework.Failf("expected at least some requests to be rejected with 503 once the queue wait bound was exceeded, got none")
+		}
+		for _, retryAfter := range results.retryAfterValues {
+			if retryAfter == "" {
+				framework.Failf("expected a Retry-After header on every 503 response")
+			}
+		}
+
+		ginkgo.By("checking that no admitted request waited longer than MaxQueueTimeSeconds")
+		maxObservedWaitSeconds := getRequestWaitDurationMaxSeconds(f, priorityLevelName)
+		if maxObservedWaitSeconds > float64(maxQueueTimeSeconds)+1 {
+			framework.Failf("observed a request wait time of %.1fs, want at most ~%ds (MaxQueueTimeSeconds)", maxObservedWaitSeconds, maxQueueTimeSeconds)
+		}
+	})
+
+	// This test creates two priority levels under load, then shrinks one of
+	// them mid-load by lowering its AssuredConcurrencyShares. We expect the
+	// controller's drain loop to shed the resulting overshoot of
+	// already-queued requests (rather than leaving them to wait against the
+	// new, smaller limit indefinitely), and the unaffected client's
+	// completion ratio to recover once the shrunk level has drained.
+	ginkgo.It("should drain queued requests when a priority level's concurrency shares shrink mid-load", func() {
+		loadDuration := 20 * time.Second
+		shrinkAfter := 5 * time.Second
+
+		type client struct {
+			username          string
+			priorityLevelName string
+			flowSchemaName    string
+			qps               float64
+			concurrency       int32
+			completedRequests int32
+		}
+		clients := []client{
+			{username: "steady", priorityLevelName: "e2e-testing-prioritylevel-steady", flowSchemaName: "e2e-testing-flowschema-steady", qps: 20.0, concurrency: 20},
+			{username: "shrinking", priorityLevelName: "e2e-testing-prioritylevel-shrinking", flowSchemaName: "e2e-testing-flowschema-shrinking", qps: 20.0, concurrency: 20},
+		}
+
+		ginkgo.By("creating test priority levels and flow schemas")
+		for i := range clients {
+			// A Queue limit response is required here, not Reject: the
+			// controller's drain loop only has a queueSet to shed requests
+			// from for priority levels using the Queue limit response.
+			_, cleanup := createPriorityLevel(f, clients[i].priorityLevelName, 10, &flowcontrol.QueuingConfiguration{
+				Queues:              64,
+				HandSize:            4,
+				QueueLengthLimit:    50,
+				MaxQueueTimeSeconds: 60,
+			}, nil)
+			defer cleanup()
+			_, cleanup = createFlowSchema(f, clients[i].flowSchemaName, 1000, clients[i].priorityLevelName, clients[i].username)
+			defer cleanup()
+		}
+
+		ginkgo.By(fmt.Sprintf("starting uniform QPS load for %s", loadDuration.String()))
+		var wg sync.WaitGroup
+		for i := range clients {
+			wg.Add(1)
+			go func(c *client) {
+				defer wg.Done()
+				c.completedRequests = uniformQPSLoadConcurrent(f, c.username, c.concurrency, c.qps, loadDuration)
+			}(&clients[i])
+		}
+
+		time.Sleep(shrinkAfter)
+		ginkgo.By(fmt.Sprintf("shrinking AssuredConcurrencyShares for priority level %q", clients[1].priorityLevelName))
+		drainedBefore := getFlowControlDrainedRequestsTotal(f, clients[1].priorityLevelName)
+		patchPriorityLevelAssuredConcurrencyShares(f, clients[1].priorityLevelName, 1)
+
+		wg.Wait()
+
+		ginkgo.By("checking that the drain counter increased for the shrunk priority level")
+		drainedAfter := getFlowControlDrainedRequestsTotal(f, clients[1].priorityLevelName)
+		if drainedAfter <= drainedBefore {
+			framework.Failf("expected apiserver_flowcontrol_drained_requests_total for priority level %q to increase after shrinking its shares, got %d before and %d after",
+				clients[1].priorityLevelName, drainedBefore, drainedAfter)
+		}
+
+		ginkgo.By("checking that the unaffected client's completion ratio recovered")
+		steady := clients[0]
+		maxCompletedRequests := float64(steady.concurrency) * steady.qps * float64(loadDuration/time.Second)
+		fractionCompleted := float64(steady.completedRequests) / maxCompletedRequests
+		framework.Logf("client %q completed %d/%d requests (%.1f%%)", steady.username, steady.completedRequests, int32(maxCompletedRequests), 100*fractionCompleted)
+		if fractionCompleted < 0.95 {
+			framework.Failf("client %q: got %.1f%% completed requests, want at least 95%%", steady.username, 100*fractionCompleted)
+		}
+	})
+
+	// This test creates a priority level with a dedicated long-running
+	// concurrency bucket and a flow schema matching the "watch" verb. One
+	// client ("floodWatches") opens many concurrent watches on pods, which
+	// are accounted against the long-running bucket, while another client
+	// ("shortReqs") sends ordinary requests against the same priority level.
+	// We expect the flood of watches to not drown out the short requests,
+	// since they're no longer sharing a single concurrency bucket.
+	ginkgo.It("should isolate long-running requests (e.g. watches) from short requests sharing a priority level", func() {
+		priorityLevelName := "e2e-testing-prioritylevel-longrunning"
+		watchFlowSchemaName := "e2e-testing-flowschema-watch"
+		shortFlowSchemaName := "e2e-testing-flowschema-short"
+		loadDuration := 10 * time.Second
+		floodConcurrency := int32(200)
+		longRunningConcurrencyShares := int32(30)
+
+		ginkgo.By("creating a testing prioritylevel with a dedicated long-running concurrency bucket")
+		_, cleanup := createPriorityLevel(f, priorityLevelName, 30, nil, &longRunningConcurrencyShares)
+		defer cleanup()
+
+		ginkgo.By("creating a flowschema matching the watch verb for the floodWatches client")
+		_, cleanup = createWatchFlowSchema(f, watchFlowSchemaName, 999, priorityLevelName, "floodWatches")
+		defer cleanup()
+
+		ginkgo.By("creating a flowschema for the shortReqs client")
+		_, cleanup = createFlowSchema(f, shortFlowSchemaName, 1000, priorityLevelName, "shortReqs")
+		defer cleanup()
+
+		ginkgo.By(fmt.Sprintf("flooding %d concurrent watches while running a uniform QPS load for %s", floodConcurrency, loadDuration.String()))
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			floodWatches(f, "floodWatches", floodConcurrency, loadDuration)
+		}()
+
+		var shortReqsCompleted int32
+		shortReqsConcurrency := int32(2)
+		shortReqsQPS := 20.0
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			shortReqsCompleted = uniformQPSLoadConcurrent(f, "shortReqs", shortReqsConcurrency, shortReqsQPS, loadDuration)
+		}()
+
+		// Give the flood of watches time to ramp up before sampling the
+		// gauge; floodWatches keeps all of them open until loadDuration
+		// elapses, and wg.Wait() below would otherwise only return once
+		// they've all already been torn down.
+		time.Sleep(2 * time.Second)
+		ginkgo.By("checking that the current-watches gauge reflects the flood")
+		currentWatches := getCurrentWatchesGauge(f, priorityLevelName, watchFlowSchemaName)
+		framework.Logf("observed %d current watches for priority level %q, flow schema %q", currentWatches, priorityLevelName, watchFlowSchemaName)
+		if currentWatches == 0 {
+			framework.Failf("expected apiserver_flowcontrol_current_watches to reflect the flood of open watches, got 0")
+		}
+
+		wg.Wait()
+
+		ginkgo.By("checking that shortReqs still achieved at least 95% completion")
+		maxCompletedRequests := float64(shortReqsConcurrency) * shortReqsQPS * float64(loadDuration/time.Second)
+		fractionCompleted := float64(shortReqsCompleted) / maxCompletedRequests
+		framework.Logf("client %q completed %d/%d requests (%.1f%%)", "shortReqs", shortReqsCompleted, int32(maxCompletedRequests), 100*fractionCompleted)
+		if fractionCompleted < 0.95 {
+			framework.Failf("client %q: got %.1f%% completed requests, want at least 95%%", "shortReqs", 100*fractionCompleted)
+		}
+	})
 })
 
 // createPriorityLevel creates a priority level with the provided assured
-// concurrency share.
-func createPriorityLevel(f *framework.Framework, priorityLevelName string, assuredConcurrencyShares int32) (*flowcontrol.PriorityLevelConfiguration, func()) {
+// concurrency share. If queuingConfig is non-nil, the priority level is
+// created with a Queue limit response using that configuration; otherwise it
+// defaults to the Reject limit response. If longRunningConcurrencyShares is
+// non-nil, long-running requests (e.g. watches) matched to this priority
+// level are accounted for separately, against their own concurrency bucket,
+// instead of being exempted from APF accounting entirely.
+func createPriorityLevel(f *framework.Framework, priorityLevelName string, assuredConcurrencyShares int32, queuingConfig *flowcontrol.QueuingConfiguration, longRunningConcurrencyShares *int32) (*flowcontrol.PriorityLevelConfiguration, func()) {
+	limitResponse := flowcontrol.LimitResponse{
+		Type: flowcontrol.LimitResponseTypeReject,
+	}
+	if queuingConfig != nil {
+		limitResponse = flowcontrol.LimitResponse{
+			Type:    flowcontrol.LimitResponseTypeQueue,
+			Queuing: queuingConfig,
+		}
+	}
+	limited := &flowcontrol.LimitedPriorityLevelConfiguration{
+		AssuredConcurrencyShares: assuredConcurrencyShares,
+		LimitResponse:            limitResponse,
+	}
+	if longRunningConcurrencyShares != nil {
+		limited.LongRunningConcurrencyShares = *longRunningConcurrencyShares
+	}
 	createdPriorityLevel, err := f.ClientSet.FlowcontrolV1beta1().PriorityLevelConfigurations().Create(
 		context.TODO(),
 		&flowcontrol.PriorityLevelConfiguration{
@@ -219,13 +447,8 @@ func createPriorityLevel(f *framework.Framework, priorityLevelName string, assur
 				Name: priorityLevelName,
 			},
 			Spec: flowcontrol.PriorityLevelConfigurationSpec{
-				Type: flowcontrol.PriorityLevelEnablementLimited,
-				Limited: &flowcontrol.LimitedPriorityLevelConfiguration{
-					AssuredConcurrencyShares: assuredConcurrencyShares,
-					LimitResponse: flowcontrol.LimitResponse{
-						Type: flowcontrol.LimitResponseTypeReject,
-					},
-				},
+				Type:    flowcontrol.PriorityLevelEnablementLimited,
+				Limited: limited,
 			},
 		},
 		metav1.CreateOptions{})
@@ -235,6 +458,104 @@ func createPriorityLevel(f *framework.Framework, priorityLevelName string, assur
 	}
 }
 
+// scrapeMetricSamples fetches and decodes the apiserver's /metrics endpoint,
+// returning every sample whose metric name matches metricName. It underlies
+// the various getXxx metric helpers in this file so each of them only has to
+// describe which samples it wants, not how to scrape and decode them.
+func scrapeMetricSamples(f *framework.Framework, metricName string) model.Vector {
+	resp, err := f.ClientSet.CoreV1().RESTClient().Get().RequestURI("/metrics").DoRaw(context.TODO())
+	framework.ExpectNoError(err)
+	sampleDecoder := expfmt.SampleDecoder{
+		Dec:  expfmt.NewDecoder(bytes.NewBuffer(resp), expfmt.FmtText),
+		Opts: &expfmt.DecodeOptions{},
+	}
+	var matched model.Vector
+	for {
+		var v model.Vector
+		err := sampleDecoder.Decode(&v)
+		if err == io.EOF {
+			break
+		}
+		framework.ExpectNoError(err)
+		for _, metric := range v {
+			if string(metric.Metric[model.MetricNameLabel]) == metricName {
+				matched = append(matched, metric)
+			}
+		}
+	}
+	return matched
+}
+
+// getRequestWaitDurationMaxSeconds scrapes the
+// apiserver_flowcontrol_request_wait_duration_seconds histogram and returns
+// the smallest bucket upper bound whose cumulative count covers every
+// successful (execute="true") observation for the given priority level,
+// i.e. an upper estimate of the longest time any admitted request spent
+// waiting in that priority level's queues. Rejected requests' wait times are
+// excluded, since they were never actually admitted to run.
+func getRequestWaitDurationMaxSeconds(f *framework.Framework, priorityLevelName string) float64 {
+	buckets := map[float64]float64{}
+	var totalCount float64
+	for _, metric := range scrapeMetricSamples(f, requestWaitDurationMetricName+"_bucket") {
+		if string(metric.Metric[requestConcurrencyLimitMetricLabelName]) != priorityLevelName {
+			continue
+		}
+		if string(metric.Metric["execute"]) != "true" {
+			continue
+		}
+		if string(metric.Metric[model.BucketLabel]) == "+Inf" {
+			totalCount += float64(metric.Value)
+			continue
+		}
+		le, err := strconv.ParseFloat(string(metric.Metric[model.BucketLabel]), 64)
+		framework.ExpectNoError(err)
+		buckets[le] += float64(metric.Value)
+	}
+	if totalCount == 0 {
+		return 0
+	}
+	maxSeconds := math.Inf(1)
+	for le, count := range buckets {
+		if count >= totalCount && le < maxSeconds {
+			maxSeconds = le
+		}
+	}
+	// if maxSeconds is still +Inf, no finite bucket's count covered every
+	// observation, i.e. some request waited past every finite boundary the
+	// histogram tracks; propagating +Inf makes that show up as a bound
+	// violation rather than as a silently-ignored zero.
+	return maxSeconds
+}
+
+// getFlowControlDrainedRequestsTotal scrapes the
+// apiserver_flowcontrol_drained_requests_total counter and returns the
+// summed value across all "reason" label values for the given priority
+// level.
+func getFlowControlDrainedRequestsTotal(f *framework.Framework, priorityLevelName string) int32 {
+	var total int32
+	for _, metric := range scrapeMetricSamples(f, drainedRequestsTotalMetricName) {
+		if string(metric.Metric[requestConcurrencyLimitMetricLabelName]) != priorityLevelName {
+			continue
+		}
+		total += int32(metric.Value)
+	}
+	return total
+}
+
+// patchPriorityLevelAssuredConcurrencyShares updates an existing priority
+// level's AssuredConcurrencyShares in place, simulating an operator (or the
+// HA-apiserver-membership controller) lowering a level's share mid-load.
+func patchPriorityLevelAssuredConcurrencyShares(f *framework.Framework, priorityLevelName string, assuredConcurrencyShares int32) {
+	patch := fmt.Sprintf(`{"spec":{"limited":{"assuredConcurrencyShares":%d}}}`, assuredConcurrencyShares)
+	_, err := f.ClientSet.FlowcontrolV1beta1().PriorityLevelConfigurations().Patch(
+		context.TODO(),
+		priorityLevelName,
+		types.MergePatchType,
+		[]byte(patch),
+		metav1.PatchOptions{})
+	framework.ExpectNoError(err)
+}
+
 func getPriorityLevelConcurrency(f *framework.Framework, priorityLevelName string) int32 {
 	resp, err := f.ClientSet.CoreV1().RESTClient().Get().RequestURI("/metrics").DoRaw(context.TODO())
 	framework.ExpectNoError(err)
@@ -317,6 +638,99 @@ func createFlowSchema(f *framework.Framework, flowSchemaName string, matchingPre
 	}
 }
 
+// createWatchFlowSchema creates a flow schema matching the "watch" verb
+// against pods in the test namespace, referring to a particular priority
+// level and matching the username provided. Matching only the "watch" verb
+// (instead of everything, as createFlowSchema does) exercises a priority
+// level's long-running concurrency bucket rather than its short-request one.
+func createWatchFlowSchema(f *framework.Framework, flowSchemaName string, matchingPrecedence int32, priorityLevelName string, matchingUsername string) (*flowcontrol.FlowSchema, func()) {
+	createdFlowSchema, err := f.ClientSet.FlowcontrolV1beta1().FlowSchemas().Create(
+		context.TODO(),
+		&flowcontrol.FlowSchema{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: flowSchemaName,
+			},
+			Spec: flowcontrol.FlowSchemaSpec{
+				MatchingPrecedence: matchingPrecedence,
+				PriorityLevelConfiguration: flowcontrol.PriorityLevelConfigurationReference{
+					Name: priorityLevelName,
+				},
+				DistinguisherMethod: &flowcontrol.FlowDistinguisherMethod{
+					Type: flowcontrol.FlowDistinguisherMethodByUserType,
+				},
+				Rules: []flowcontrol.PolicyRulesWithSubjects{
+					{
+						Subjects: []flowcontrol.Subject{
+							{
+								Kind: flowcontrol.SubjectKindUser,
+								User: &flowcontrol.UserSubject{
+									Name: matchingUsername,
+								},
+							},
+						},
+						ResourceRules: []flowcontrol.ResourcePolicyRule{
+							{
+								Verbs:      []string{"watch"},
+								APIGroups:  []string{""},
+								Resources:  []string{"pods"},
+								Namespaces: []string{f.Namespace.Name},
+							},
+						},
+					},
+				},
+			},
+		},
+		metav1.CreateOptions{})
+	framework.ExpectNoError(err)
+	return createdFlowSchema, func() {
+		framework.ExpectNoError(f.ClientSet.FlowcontrolV1beta1().FlowSchemas().Delete(context.TODO(), flowSchemaName, metav1.DeleteOptions{}))
+	}
+}
+
+// floodWatches opens <concurrency> concurrent watches on pods, impersonating
+// <username>, and keeps them open for <loadDuration> before stopping them
+// all. It is used to simulate a client that drowns a priority level in
+// long-running requests.
+func floodWatches(f *framework.Framework, username string, concurrency int32, loadDuration time.Duration) {
+	config := f.ClientConfig()
+	config.Impersonate.UserName = username
+	config.Impersonate.Groups = []string{"system:authenticated"}
+	clientSet, err := kubernetes.NewForConfig(config)
+	framework.ExpectNoError(err)
+
+	var wg sync.WaitGroup
+	wg.Add(int(concurrency))
+	for i := int32(0); i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			watcher, err := clientSet.CoreV1().Pods(f.Namespace.Name).Watch(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				framework.Logf("floodWatches: failed to open watch: %v", err)
+				return
+			}
+			defer watcher.Stop()
+			time.Sleep(loadDuration)
+		}()
+	}
+	wg.Wait()
+}
+
+// getCurrentWatchesGauge scrapes the apiserver_flowcontrol_current_watches
+// gauge for the given priority level and flow schema.
+func getCurrentWatchesGauge(f *framework.Framework, priorityLevelName, flowSchemaName string) int32 {
+	var total int32
+	for _, metric := range scrapeMetricSamples(f, currentWatchesMetricName) {
+		if string(metric.Metric[requestConcurrencyLimitMetricLabelName]) != priorityLevelName {
+			continue
+		}
+		if string(metric.Metric[flowSchemaMetricLabelName]) != flowSchemaName {
+			continue
+		}
+		total += int32(metric.Value)
+	}
+	return total
+}
+
 // makeRequests creates a request to the API server and returns the response.
 func makeRequest(f *framework.Framework, username string) *http.Response {
 	config := f.ClientConfig()
@@ -396,3 +810,73 @@ func uniformQPSLoadConcurrent(f *framework.Framework, username string, concurren
 	wg.Wait()
 	return completed
 }
+
+// loadResults aggregates the outcomes of a uniform QPS load run by response
+// status, for tests that care about more than just the completed count (e.g.
+// distinguishing admitted requests from those rejected for being over
+// capacity).
+type loadResults struct {
+	succeeded          int32
+	serviceUnavailable int32
+	retryAfterValuesMu sync.Mutex
+	retryAfterValues   []string
+}
+
+func (r *loadResults) recordServiceUnavailable(retryAfter string) {
+	atomic.AddInt32(&r.serviceUnavailable, 1)
+	r.retryAfterValuesMu.Lock()
+	defer r.retryAfterValuesMu.Unlock()
+	r.retryAfterValues = append(r.retryAfterValues, retryAfter)
+}
+
+// uniformQPSLoadSingleWithStatus behaves like uniformQPSLoadSingle but
+// classifies every response instead of only counting completions, so that
+// callers can distinguish admitted (2xx) requests from those rejected with a
+// 503 once a priority level's queue wait bound is exceeded.
+func uniformQPSLoadSingleWithStatus(f *framework.Framework, username string, qps float64, loadDuration time.Duration) *loadResults {
+	results := &loadResults{}
+	var wg sync.WaitGroup
+	ticker := time.NewTicker(time.Duration(1e9/qps) * time.Nanosecond)
+	defer ticker.Stop()
+	timer := time.NewTimer(loadDuration)
+	for {
+		select {
+		case <-ticker.C:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				response := makeRequest(f, username)
+				switch {
+				case response.StatusCode == http.StatusServiceUnavailable:
+					results.recordServiceUnavailable(response.Header.Get("Retry-After"))
+				case response.StatusCode >= 200 && response.StatusCode < 300:
+					atomic.AddInt32(&results.succeeded, 1)
+				}
+			}()
+		case <-timer.C:
+			wg.Wait() // do not leak goroutines
+			return results
+		}
+	}
+}
+
+// uniformQPSLoadConcurrentWithStatus is the status-classifying counterpart of
+// uniformQPSLoadConcurrent.
+func uniformQPSLoadConcurrentWithStatus(f *framework.Framework, username string, concurrency int32, qps float64, loadDuration time.Duration) *loadResults {
+	total := &loadResults{}
+	var wg sync.WaitGroup
+	wg.Add(int(concurrency))
+	for i := int32(0); i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			results := uniformQPSLoadSingleWithStatus(f, username, qps, loadDuration)
+			atomic.AddInt32(&total.succeeded, results.succeeded)
+			atomic.AddInt32(&total.serviceUnavailable, results.serviceUnavailable)
+			total.retryAfterValuesMu.Lock()
+			total.retryAfterValues = append(total.retryAfterValues, results.retryAfterValues...)
+			total.retryAfterValuesMu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return total
+}